@@ -0,0 +1,222 @@
+package quasar
+
+import "crypto/ed25519"
+
+// replayWindowSize mirrors WireGuard's replay.go: the last N counters
+// from a peer are remembered so an out-of-order-but-recent delivery is
+// accepted while a replayed one is rejected.
+const replayWindowSize = 1024
+
+// replayFilter is a sliding-window replay filter for one peer: a
+// bitmap of the last replayWindowSize counters seen, plus the highest
+// counter seen so far.
+type replayFilter struct {
+	highest uint64
+	seen    [replayWindowSize]bool
+}
+
+func newReplayFilter() *replayFilter {
+	return &replayFilter{}
+}
+
+// accept reports whether counter is new (either above the highest
+// counter seen so far, or within the window below it and not already
+// marked), marking it seen if so.
+// Counter 0 is never valid: it's reserved to mean "never signed".
+func (r *replayFilter) accept(counter uint64) bool {
+	if counter == 0 {
+		return false
+	}
+	if counter > r.highest {
+		shift := counter - r.highest
+		if shift >= replayWindowSize {
+			for i := range r.seen {
+				r.seen[i] = false
+			}
+		} else {
+			for i := replayWindowSize - 1; i >= int(shift); i-- {
+				r.seen[i] = r.seen[i-int(shift)]
+			}
+			for i := 0; i < int(shift); i++ {
+				r.seen[i] = false
+			}
+		}
+		r.highest = counter
+		r.seen[0] = true
+		return true
+	}
+	diff := r.highest - counter
+	if diff >= replayWindowSize {
+		return false // too far behind the window to tell
+	}
+	if r.seen[diff] {
+		return false // duplicate
+	}
+	r.seen[diff] = true
+	return true
+}
+
+// verifyFrame checks an Ed25519 signature over payload against the
+// pubkey the frame claims as its sender.
+func verifyFrame(p pubkey, payload, signature []byte) bool {
+	if len(signature) == 0 {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(p[:]), payload, signature)
+}
+
+// SetSigner gives the Node an Ed25519 private key to sign outbound
+// events with. Required for Config.RequireSignatures to be useful
+// against peers that enforce it.
+func (n *Node) SetSigner(priv ed25519.PrivateKey) {
+	n.mutex.Lock()
+	n.signer = priv
+	n.mutex.Unlock()
+}
+
+// signEvent assigns e the next monotonic counter and, if this Node has
+// a signer configured, an Ed25519 signature over its encoded payload.
+// Publish calls this once for the originating hop, and route calls it
+// again before relaying onward, so the signature the next hop verifies
+// is always produced by whoever is actually forwarding the event.
+func (n *Node) signEvent(e *event) {
+	n.mutex.Lock()
+	n.nextCounter++
+	e.counter = n.nextCounter
+	signer := n.signer
+	n.mutex.Unlock()
+	if signer == nil {
+		return
+	}
+	e.signature = ed25519.Sign(signer, encodeEventPayload(e))
+}
+
+// PrepareUpdate builds a counter-stamped update, signed if this Node has
+// a signer configured. sendUpdates does not call it: networkOverlay's
+// sendUpdate takes only the target, index and filter, so the filter
+// updates a Node sends out carry no counter or signature.
+func (n *Node) PrepareUpdate(peer *pubkey, index uint32, filter []byte) *update {
+	n.mutex.Lock()
+	n.nextCounter++
+	u := &update{peer: peer, index: index, filter: filter, counter: n.nextCounter}
+	signer := n.signer
+	n.mutex.Unlock()
+	if signer != nil {
+		u.signature = ed25519.Sign(signer, encodeUpdatePayload(u))
+	}
+	return u
+}
+
+// authenticateUpdate verifies u's signature (when Config.RequireSignatures
+// is set) and checks its counter against the per-peer replay filter.
+// Called directly from dispatchInput for every inbound update, before
+// validUpdate and rate limiting. It is a no-op pass when signatures
+// aren't required.
+func (n *Node) authenticateUpdate(u *update) bool {
+	if !n.cfg.RequireSignatures {
+		return true
+	}
+	if u == nil || u.peer == nil {
+		return false
+	}
+	if !verifyFrame(*u.peer, encodeUpdatePayload(u), u.signature) {
+		return false
+	}
+	n.mutex.Lock()
+	rf, ok := n.replay[*u.peer]
+	if !ok {
+		rf = newReplayFilter()
+		n.replay[*u.peer] = rf
+	}
+	accepted := rf.accept(u.counter)
+	n.mutex.Unlock()
+	return accepted
+}
+
+// signSubscribeRequest assigns req, if this Node has a signer
+// configured, an Ed25519 signature over encodeSubscribeRequestPayload(req)
+// (which includes the ttl). Unlike signEvent/PrepareUpdate it stamps no
+// counter, so subscribeRequests are authenticated but not replay-checked.
+func (n *Node) signSubscribeRequest(req *subscribeRequest) {
+	n.mutex.Lock()
+	signer := n.signer
+	n.mutex.Unlock()
+	if signer == nil {
+		return
+	}
+	req.signature = ed25519.Sign(signer, encodeSubscribeRequestPayload(req))
+}
+
+// authenticateSubscribeRequest verifies req's signature against its
+// claimed requester (when Config.RequireSignatures is set). Without it,
+// any peer could claim to be any pubkey in requester and register or
+// cancel that peer's light subscription. It is a no-op pass when
+// signatures aren't required.
+func (n *Node) authenticateSubscribeRequest(req *subscribeRequest) bool {
+	if !n.cfg.RequireSignatures {
+		return true
+	}
+	if req == nil || req.requester == nil {
+		return false
+	}
+	return verifyFrame(*req.requester, encodeSubscribeRequestPayload(req), req.signature)
+}
+
+// signSubscriptionChange assigns sc an Ed25519 signature over
+// subscriptionChangeKey(sc), if this Node has a signer configured, so
+// the peer it claims to be (sc.peer) can be verified by whoever
+// receives it instead of taken on trust.
+func (n *Node) signSubscriptionChange(sc *subscriptionChange) {
+	n.mutex.Lock()
+	signer := n.signer
+	n.mutex.Unlock()
+	if signer == nil {
+		return
+	}
+	sc.signature = ed25519.Sign(signer, subscriptionChangeKey(sc))
+}
+
+// authenticateSubscriptionChange verifies sc's signature against its
+// claimed peer (when Config.RequireSignatures is set). Without it, any
+// connected peer could claim to be any pubkey in sc.peer and poison
+// that peer's cached routing filter. It is a no-op pass when signatures
+// aren't required.
+func (n *Node) authenticateSubscriptionChange(sc *subscriptionChange) bool {
+	if !n.cfg.RequireSignatures {
+		return true
+	}
+	if sc == nil || sc.peer == nil {
+		return false
+	}
+	return verifyFrame(*sc.peer, subscriptionChangeKey(sc), sc.signature)
+}
+
+// authenticateEvent verifies e's signature (when Config.RequireSignatures
+// is set) against e's hop sender — whichever peer the overlay says just
+// forwarded it, not the app-level publishers chain — and checks its
+// counter against that peer's replay filter. It is a no-op pass when
+// signatures aren't required.
+func (n *Node) authenticateEvent(e *event) bool {
+	if !n.cfg.RequireSignatures {
+		return true
+	}
+	if e == nil {
+		return false
+	}
+	sender := eventSender(e)
+	if sender == unknownEventSender {
+		return false
+	}
+	if !verifyFrame(sender, encodeEventPayload(e), e.signature) {
+		return false
+	}
+	n.mutex.Lock()
+	rf, ok := n.replay[sender]
+	if !ok {
+		rf = newReplayFilter()
+		n.replay[sender] = rf
+	}
+	accepted := rf.accept(e.counter)
+	n.mutex.Unlock()
+	return accepted
+}
@@ -0,0 +1,178 @@
+package quasar
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestReplayFilterAcceptsOutOfOrderWithinWindow(t *testing.T) {
+	r := newReplayFilter()
+	if !r.accept(5) {
+		t.Fatal("expected first counter to be accepted")
+	}
+	if !r.accept(3) {
+		t.Error("expected an older but in-window counter to be accepted")
+	}
+	if !r.accept(4) {
+		t.Error("expected a gap-filling in-window counter to be accepted")
+	}
+}
+
+func TestReplayFilterRejectsDuplicate(t *testing.T) {
+	r := newReplayFilter()
+	if !r.accept(10) {
+		t.Fatal("expected first counter to be accepted")
+	}
+	if r.accept(10) {
+		t.Error("expected a repeated counter to be rejected")
+	}
+}
+
+func TestReplayFilterRejectsZero(t *testing.T) {
+	r := newReplayFilter()
+	if r.accept(0) {
+		t.Error("expected counter 0 to always be rejected")
+	}
+}
+
+func TestReplayFilterRejectsBelowWindow(t *testing.T) {
+	r := newReplayFilter()
+	r.accept(replayWindowSize + 100)
+	if r.accept(50) {
+		t.Error("expected a counter far behind the window to be rejected")
+	}
+}
+
+func testAuthConfig() *Config {
+	c := StandardConfig
+	c.RequireSignatures = true
+	return &c
+}
+
+func TestAuthenticateEventAcceptsValidSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var sender pubkey
+	copy(sender[:], pub)
+
+	n := NewCustom(nil, testAuthConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, ttl: 5, hopSender: &sender, message: []byte("hi")}
+	n.signEvent(e)
+
+	if !n.authenticateEvent(e) {
+		t.Error("expected a correctly signed event to authenticate")
+	}
+}
+
+func TestAuthenticateEventRejectsForgedSignature(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	forgedPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var claimedSender pubkey
+	copy(claimedSender[:], forgedPub) // claims to be from a key it never signed with
+
+	n := NewCustom(nil, testAuthConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, ttl: 5, hopSender: &claimedSender, message: []byte("hi")}
+	n.signEvent(e)
+
+	if n.authenticateEvent(e) {
+		t.Error("expected an event signed by a different key than it claims to be rejected")
+	}
+}
+
+func TestAuthenticateEventRejectsReplay(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var sender pubkey
+	copy(sender[:], pub)
+
+	n := NewCustom(nil, testAuthConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, ttl: 5, hopSender: &sender, message: []byte("hi")}
+	n.signEvent(e)
+
+	if !n.authenticateEvent(e) {
+		t.Fatal("expected the first delivery to authenticate")
+	}
+	if n.authenticateEvent(e) {
+		t.Error("expected a replayed event to be rejected")
+	}
+}
+
+func TestAuthenticateEventPassesWhenSignaturesNotRequired(t *testing.T) {
+	n := NewCustom(nil, &StandardConfig)
+	var digest hash160digest
+	e := &event{topicDigest: &digest, ttl: 5, message: []byte("hi")}
+	if !n.authenticateEvent(e) {
+		t.Error("expected authenticateEvent to pass through when RequireSignatures is false")
+	}
+}
+
+// TestRouteSignsAndAttributesEventAcrossRealHops drives an event through
+// route() and dispatchInput on two linked fakeOverlays, the way a real
+// relay actually happens, rather than hand-building an event with a
+// publishers entry that route() itself rarely populates. It checks that
+// the receiving node's rate limiter sees the sending node's real pubkey
+// (attributed by the overlay as e.hopSender) instead of falling back to
+// unknownEventSender.
+func TestRouteSignsAndAttributesEventAcrossRealHops(t *testing.T) {
+	c := testAuthConfig()
+
+	var aId, bId pubkey
+	copy(bId[:], []byte("node-b-aaaaaaaaaaaaaaaaaaaaaaaaa"))
+
+	aPub, aPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	copy(aId[:], aPub)
+
+	foA := newFakeOverlay(aId)
+	foB := newFakeOverlay(bId)
+	foA.link(foB)
+
+	a := newNode(foA, nil, c)
+	a.SetSigner(aPriv)
+
+	b := newNode(foB, nil, c)
+	b.stopDispatcher = make(chan bool)
+	go b.dispatchInput()
+	defer func() { b.stopDispatcher <- true }()
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, ttl: 5, message: []byte("hi")}
+	a.signEvent(e)
+	a.route(e)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.limiter.mutex.Lock()
+		_, tracked := b.limiter.peers[aId]
+		b.limiter.mutex.Unlock()
+		if tracked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected b's rate limiter to eventually track a's real pubkey as the event's sender")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
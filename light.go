@@ -0,0 +1,216 @@
+package quasar
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+)
+
+// subscribeRequest is sent by a light node to a full peer to register
+// (or, with unsubscribe set, remove) interest in a topic without that
+// light node participating in filter aggregation/propagation itself.
+type subscribeRequest struct {
+	requester   *pubkey
+	topicDigest hash160digest
+	ttl         uint32 // seconds until the full peer expires this registration absent a refresh
+	unsubscribe bool
+	signature   []byte
+}
+
+func validSubscribeRequest(req *subscribeRequest) bool {
+	return req != nil && req.requester != nil && (req.unsubscribe || req.ttl > 0)
+}
+
+// encodeSubscribeRequestPayload writes requester pubkey, topic digest,
+// ttl and unsubscribe as requester | digest | ttl(varint) | unsubscribe,
+// exactly the byte range a subscribeRequest's signature is computed
+// over (see signSubscribeRequest/authenticateSubscribeRequest).
+func encodeSubscribeRequestPayload(req *subscribeRequest) []byte {
+	var ttlBuf [binary.MaxVarintLen32]byte
+	ttlLen := binary.PutUvarint(ttlBuf[:], uint64(req.ttl))
+
+	buf := make([]byte, 0, pubkeySize+hash160Size+ttlLen+1)
+	buf = append(buf, req.requester[:]...)
+	buf = append(buf, req.topicDigest[:]...)
+	buf = append(buf, ttlBuf[:ttlLen]...)
+	if req.unsubscribe {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// filterPush carries a single matching event from a full peer back to
+// a light node that registered a subscribeRequest for its topic.
+// sender/signature let the receiving light node verify the push
+// actually came from one of its configured full peers (see
+// SetFullPeers/authenticateFilterPush), rather than trusting whichever
+// connected peer happened to send one.
+type filterPush struct {
+	topicDigest hash160digest
+	message     []byte
+	sender      *pubkey
+	signature   []byte
+}
+
+// encodeFilterPushPayload writes topic digest and message as
+// digest | message, exactly the byte range a filterPush's signature is
+// computed over.
+func encodeFilterPushPayload(push *filterPush) []byte {
+	buf := make([]byte, 0, hash160Size+len(push.message))
+	buf = append(buf, push.topicDigest[:]...)
+	buf = append(buf, push.message...)
+	return buf
+}
+
+// lightSubscription is what a full node remembers about one light
+// peer's interest in one topic: the deadline after which it is
+// considered stale absent a refreshing subscribeRequest.
+type lightSubscription struct {
+	expiresAt uint64 // makePeerTimestamp() deadline
+}
+
+// SetFullPeers configures the full peers a LightMode node sends
+// subscribeRequests to. Subscribe/Unsubscribe only send those requests
+// when Config.LightMode is set; authenticateFilterPush uses the same
+// list, whatever the mode, to decide which filterPush senders to trust.
+func (n *Node) SetFullPeers(peers []*pubkey) {
+	n.mutex.Lock()
+	n.fullPeers = peers
+	n.mutex.Unlock()
+}
+
+// sendSubscribeRequest asks every configured full peer to register (or,
+// with unsubscribe set, drop) this light node's interest in digest.
+func (n *Node) sendSubscribeRequest(digest hash160digest, unsubscribe bool) {
+	n.mutex.RLock()
+	peers := make([]*pubkey, len(n.fullPeers))
+	copy(peers, n.fullPeers)
+	id := n.net.id()
+	n.mutex.RUnlock()
+
+	req := &subscribeRequest{
+		requester:   &id,
+		topicDigest: digest,
+		ttl:         n.cfg.LightSubscriptionTTL,
+		unsubscribe: unsubscribe,
+	}
+	n.signSubscribeRequest(req)
+	for _, p := range peers {
+		go n.net.sendSubscribeRequest(p, req)
+	}
+}
+
+// handleSubscribeRequest is the full-node counterpart of
+// sendSubscribeRequest: it registers, refreshes or removes a light
+// peer's interest in req.topicDigest.
+func (n *Node) handleSubscribeRequest(req *subscribeRequest) {
+	if !validSubscribeRequest(req) || !n.authenticateSubscribeRequest(req) {
+		return
+	}
+	n.mutex.Lock()
+	if req.unsubscribe {
+		if topics, ok := n.lightSubs[*req.requester]; ok {
+			delete(topics, req.topicDigest)
+			if len(topics) == 0 {
+				delete(n.lightSubs, *req.requester)
+			}
+		}
+	} else {
+		topics, ok := n.lightSubs[*req.requester]
+		if !ok {
+			topics = make(map[hash160digest]*lightSubscription)
+			n.lightSubs[*req.requester] = topics
+		}
+		topics[req.topicDigest] = &lightSubscription{
+			expiresAt: makePeerTimestamp() + uint64(req.ttl)*1000,
+		}
+	}
+	n.mutex.Unlock()
+}
+
+// pushToLightSubscribers forwards e to every light peer currently
+// subscribed to its topic, over the persistent filterPush path rather
+// than the normal filter-routed event path. Callers must hold n.mutex
+// for reading, so signing is done inline here (reading n.signer
+// directly) rather than via a Node method that takes its own lock.
+func (n *Node) pushToLightSubscribers(e *event) {
+	if e == nil || e.topicDigest == nil {
+		return
+	}
+	now := makePeerTimestamp()
+	id := n.net.id()
+	signer := n.signer
+	for requester, topics := range n.lightSubs {
+		sub, ok := topics[*e.topicDigest]
+		if !ok || sub.expiresAt <= now {
+			continue
+		}
+		requester := requester
+		push := &filterPush{topicDigest: *e.topicDigest, message: e.message, sender: &id}
+		if signer != nil {
+			push.signature = ed25519.Sign(signer, encodeFilterPushPayload(push))
+		}
+		go n.net.sendFilterPush(&requester, push)
+	}
+}
+
+// authenticateFilterPush verifies push's signature against its claimed
+// sender (when Config.RequireSignatures is set) and checks that sender
+// is one of this node's configured full peers — the trust boundary
+// pushToLightSubscribers relies on, since otherwise any connected peer
+// could forge a filterPush for a topic this light node happens to be
+// subscribed to. It is a no-op pass when signatures aren't required.
+func (n *Node) authenticateFilterPush(push *filterPush) bool {
+	if !n.cfg.RequireSignatures {
+		return true
+	}
+	if push == nil || push.sender == nil {
+		return false
+	}
+	if !verifyFrame(*push.sender, encodeFilterPushPayload(push), push.signature) {
+		return false
+	}
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	for _, p := range n.fullPeers {
+		if *p == *push.sender {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFilterPush delivers a pushed event to this (light) node's own
+// Subscribers, exactly as route() would for a normally-routed event.
+func (n *Node) handleFilterPush(push *filterPush) {
+	if push == nil || !n.authenticateFilterPush(push) {
+		return
+	}
+	n.mutex.RLock()
+	receivers, ok := n.subscribers[push.topicDigest]
+	n.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	digest := push.topicDigest
+	n.deliver(receivers, &event{topicDigest: &digest, message: push.message})
+}
+
+// removeExpiredLightSubs drops light-peer subscriptions past their TTL,
+// mirroring removeExpiredPeers for the full-node subscription map.
+func (n *Node) removeExpiredLightSubs() {
+	n.mutex.Lock()
+	now := makePeerTimestamp()
+	for requester, topics := range n.lightSubs {
+		for digest, sub := range topics {
+			if sub.expiresAt <= now {
+				delete(topics, digest)
+			}
+		}
+		if len(topics) == 0 {
+			delete(n.lightSubs, requester)
+		}
+	}
+	n.mutex.Unlock()
+}
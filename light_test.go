@@ -0,0 +1,187 @@
+package quasar
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func testLightConfig() *Config {
+	c := StandardConfig
+	c.RequireSignatures = true
+	c.LightSubscriptionTTL = 60
+	return &c
+}
+
+func TestHandleSubscribeRequestRejectsForgedRequester(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	forgedPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var claimedRequester pubkey
+	copy(claimedRequester[:], forgedPub) // claims to be a requester it never signed as
+
+	n := NewCustom(nil, testLightConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	req := &subscribeRequest{requester: &claimedRequester, topicDigest: digest, ttl: 60}
+	n.signSubscribeRequest(req)
+
+	n.handleSubscribeRequest(req)
+
+	if _, ok := n.lightSubs[claimedRequester]; ok {
+		t.Error("expected a subscribeRequest signed by a different key than it claims to be rejected")
+	}
+}
+
+func TestHandleSubscribeRequestAcceptsValidSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var requester pubkey
+	copy(requester[:], pub)
+
+	n := NewCustom(nil, testLightConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	req := &subscribeRequest{requester: &requester, topicDigest: digest, ttl: 60}
+	n.signSubscribeRequest(req)
+
+	n.handleSubscribeRequest(req)
+
+	if _, ok := n.lightSubs[requester]; !ok {
+		t.Error("expected a correctly signed subscribeRequest to register the light subscription")
+	}
+}
+
+func TestSendSubscribeRequestSignsItsRequest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var self pubkey
+	copy(self[:], pub)
+
+	fo := newFakeOverlay(self)
+	var full pubkey
+	copy(full[:], []byte("full-peer-aaaaaaaaaaaaaaaaaaaaaaa"))
+	fo.connected[full] = true
+	fullOverlay := newFakeOverlay(full)
+	fo.link(fullOverlay)
+
+	n := newNode(fo, nil, testLightConfig())
+	n.SetSigner(priv)
+	n.SetFullPeers([]*pubkey{&full})
+
+	var digest hash160digest
+	n.sendSubscribeRequest(digest, false)
+
+	select {
+	case req := <-fullOverlay.subscribeRequests:
+		if len(req.signature) == 0 {
+			t.Error("expected sendSubscribeRequest to sign the outgoing request")
+		}
+		if !verifyFrame(self, encodeSubscribeRequestPayload(req), req.signature) {
+			t.Error("expected the request's signature to verify against the requester pubkey")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a subscribeRequest to have been sent to the full peer")
+	}
+}
+
+func TestHandleFilterPushRejectsUntrustedSender(t *testing.T) {
+	strangerPub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var fullPeer, stranger pubkey
+	copy(fullPeer[:], []byte("trusted-full-peer-aaaaaaaaaaaaaa"))
+	copy(stranger[:], strangerPub)
+
+	n := newNode(newFakeOverlay(pubkey{}), nil, testLightConfig())
+	n.SetFullPeers([]*pubkey{&fullPeer})
+
+	var receiver bytes.Buffer
+	n.Subscribe([]byte("some-topic"), &receiver)
+
+	digest := hash160([]byte("some-topic"))
+	push := &filterPush{topicDigest: digest, message: []byte("spoofed"), sender: &stranger}
+	push.signature = ed25519.Sign(priv, encodeFilterPushPayload(push)) // validly signed, just not by a configured full peer
+
+	n.handleFilterPush(push)
+
+	if receiver.Len() != 0 {
+		t.Error("expected a filterPush from an unconfigured full peer to be rejected")
+	}
+}
+
+func TestHandleFilterPushAcceptsConfiguredFullPeer(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var fullPeer pubkey
+	copy(fullPeer[:], pub)
+
+	n := newNode(newFakeOverlay(pubkey{}), nil, testLightConfig())
+	n.SetFullPeers([]*pubkey{&fullPeer})
+
+	var receiver bytes.Buffer
+	n.Subscribe([]byte("some-topic"), &receiver)
+
+	digest := hash160([]byte("some-topic"))
+	push := &filterPush{topicDigest: digest, message: []byte("hi"), sender: &fullPeer}
+	push.signature = ed25519.Sign(priv, encodeFilterPushPayload(push))
+
+	n.handleFilterPush(push)
+
+	if receiver.String() != "hi" {
+		t.Error("expected a filterPush from a configured full peer to be delivered")
+	}
+}
+
+func TestPushToLightSubscribersSignsItsPush(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var self, requester pubkey
+	copy(self[:], pub)
+	copy(requester[:], []byte("light-subscriber-aaaaaaaaaaaaaaa"))
+
+	fo := newFakeOverlay(self)
+	fo.connected[requester] = true
+	lightOverlay := newFakeOverlay(requester)
+	fo.link(lightOverlay)
+
+	n := newNode(fo, nil, testLightConfig())
+	n.SetSigner(priv)
+	n.mutex.Lock()
+	digest := hash160([]byte("some-topic"))
+	n.lightSubs[requester] = map[hash160digest]*lightSubscription{
+		digest: {expiresAt: makePeerTimestamp() + 60000},
+	}
+	n.mutex.Unlock()
+
+	n.pushToLightSubscribers(&event{topicDigest: &digest, message: []byte("hi")})
+
+	select {
+	case push := <-lightOverlay.filterPushes:
+		if push.sender == nil || *push.sender != self {
+			t.Fatal("expected pushToLightSubscribers to attribute itself as the push's sender")
+		}
+		if !verifyFrame(self, encodeFilterPushPayload(push), push.signature) {
+			t.Error("expected the push's signature to verify against the sending full peer's pubkey")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a filterPush to have been sent to the light subscriber")
+	}
+}
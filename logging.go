@@ -14,6 +14,14 @@ type LogEvent struct {
 	target *pubkey
 }
 
+// LogSubscriptionChange used for monitoring eager join/leave notifications.
+type LogSubscriptionChange struct {
+	node   *Node
+	peer   *pubkey
+	digest hash160digest
+	join   bool
+}
+
 // Logger provides a logger used by Node nodes for logging internals.
 type Logger struct {
 	UpdatesSent         chan *LogUpdate
@@ -28,6 +36,8 @@ type Logger struct {
 	EventsRouteDirect   chan *LogEvent
 	EventsRouteWell     chan *LogEvent
 	EventsRouteRandom   chan *LogEvent
+	EventsDropRateLimit chan *LogEvent
+	EventsSubscriptionChange chan *LogSubscriptionChange
 	// TODO add overlay network logging
 }
 
@@ -46,6 +56,8 @@ func NewLogger() *Logger {
 		EventsRouteDirect:   make(chan *LogEvent),
 		EventsRouteWell:     make(chan *LogEvent),
 		EventsRouteRandom:   make(chan *LogEvent),
+		EventsDropRateLimit: make(chan *LogEvent),
+		EventsSubscriptionChange: make(chan *LogSubscriptionChange),
 	}
 }
 
@@ -150,3 +162,19 @@ func (l *Logger) eventRouteRandom(n *Node, e *event, t *pubkey) {
 		}
 	}
 }
+
+func (l *Logger) eventDropRateLimit(n *Node, e *event, t *pubkey) {
+	if l != nil && l.EventsDropRateLimit != nil {
+		l.EventsDropRateLimit <- &LogEvent{
+			node: n, entry: e, target: t,
+		}
+	}
+}
+
+func (l *Logger) subscriptionChange(n *Node, peer *pubkey, digest hash160digest, join bool) {
+	if l != nil && l.EventsSubscriptionChange != nil {
+		l.EventsSubscriptionChange <- &LogSubscriptionChange{
+			node: n, peer: peer, digest: digest, join: join,
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package quasar
 
 import (
+	"crypto/ed25519"
 	"github.com/f483/dejavu"
 	"io"
-	"math/rand"
 	"sync"
 	"time"
 )
 
+// peerEventsBufferSize bounds how many PeerEvents queue up for
+// PeerEvents() before new ones are dropped.
+const peerEventsBufferSize = 256
+
 // Node holds the quasar pubsup state
 type Node struct {
 	net               networkOverlay
@@ -18,6 +22,18 @@ type Node struct {
 	log               *Logger
 	history           dejavu.DejaVu // memory of past events
 	cfg               *Config
+	codec             Codec
+	tracer            Tracer
+	scores            map[pubkey]*peerScore
+	recentRoutes      map[string]routedEvent
+	limiter           *rateLimiter
+	fullPeers         []*pubkey                                  // light mode: full peers to subscribe through
+	lightSubs         map[pubkey]map[hash160digest]*lightSubscription // full mode: requester -> topic digests
+	scHistory         dejavu.DejaVu // memory of applied subscriptionChange notifications
+	peerEvents        chan PeerEvent
+	signer            ed25519.PrivateKey      // nil unless SetSigner was called
+	nextCounter       uint64                  // monotonic counter stamped on signed frames
+	replay            map[pubkey]*replayFilter // per-peer replay protection
 	stopDispatcher    chan bool
 	stopPropagation   chan bool
 	stopExpiredPeerGC chan bool
@@ -45,6 +61,18 @@ func newNode(n networkOverlay, l *Logger, c *Config) *Node {
 		log:               l,
 		history:           d,
 		cfg:               c,
+		codec:             defaultCodec,
+		tracer:            nil,
+		scores:            make(map[pubkey]*peerScore),
+		recentRoutes:      make(map[string]routedEvent),
+		limiter:           newRateLimiter(c),
+		fullPeers:         nil,
+		lightSubs:         make(map[pubkey]map[hash160digest]*lightSubscription),
+		scHistory:         dejavu.NewProbabilistic(c.HistoryLimit, c.HistoryAccuracy),
+		peerEvents:        make(chan PeerEvent, peerEventsBufferSize),
+		signer:            nil,
+		nextCounter:       0,
+		replay:            make(map[pubkey]*replayFilter),
 		stopDispatcher:    nil, // set on Start() call
 		stopPropagation:   nil, // set on Start() call
 		stopExpiredPeerGC: nil, // set on Start() call
@@ -53,8 +81,11 @@ func newNode(n networkOverlay, l *Logger, c *Config) *Node {
 
 func (n *Node) processUpdate(u *peerUpdate) {
 	go n.log.updateReceived(n, u)
+	n.traceUpdate(TraceUpdateRcv, u.index, u.peer)
 	if n.net.isConnected(u.peer) == false {
 		go n.log.updateFail(n, u)
+		n.traceUpdate(TraceUpdateFail, u.index, u.peer)
+		n.recordUpdateApplied(u.peer, false)
 		return // ignore to prevent memory attack
 	}
 
@@ -75,13 +106,17 @@ func (n *Node) processUpdate(u *peerUpdate) {
 	data.timestamps[u.index] = makePeerTimestamp()
 	n.mutex.Unlock()
 	go n.log.updateSuccess(n, u)
+	n.traceUpdate(TraceUpdateSuccess, u.index, u.peer)
+	n.recordUpdateApplied(u.peer, true)
 }
 
 // Publish a message on the network for given topic.
 func (n *Node) Publish(topic []byte, message []byte) {
 	// TODO validate input
 	event := newEvent(topic, message, n.cfg.DefaultEventTTL)
+	n.signEvent(event)
 	go n.log.eventPublished(n, event)
+	n.traceEvent(TracePublish, event, nil)
 	go n.route(event)
 }
 
@@ -105,6 +140,9 @@ func (n *Node) subscriptions() []hash160digest {
 
 // Algorithm 1 from the quasar paper.
 func (n *Node) sendUpdates() {
+	if n.cfg.LightMode {
+		return // light nodes don't aggregate/propagate filters
+	}
 	n.mutex.RLock()
 	filters := newFilters(n.cfg)
 	pubkey := n.net.id()
@@ -123,14 +161,16 @@ func (n *Node) sendUpdates() {
 			}
 		}
 	}
-	for _, id := range n.net.connectedPeers() {
+	peers := n.net.connectedPeers()
+	n.mutex.RUnlock()
+	for _, id := range peers {
 		for i := 0; uint32(i) < (n.cfg.FiltersDepth - 1); i++ {
 			// top filter never sent as not used by peers
 			go n.net.sendUpdate(id, uint32(i), filters[i])
 			go n.log.updateSent(n, uint32(i), filters[i], id)
+			n.traceUpdate(TraceUpdateSent, uint32(i), id)
 		}
 	}
-	n.mutex.RUnlock()
 }
 
 // Algorithm 2 from the quasar paper.
@@ -138,29 +178,39 @@ func (n *Node) route(e *event) {
 	n.mutex.RLock()
 	id := n.net.id()
 	if n.isDuplicate(e) {
-		go n.log.eventDropDuplicate(n, e)
 		n.mutex.RUnlock()
+		go n.log.eventDropDuplicate(n, e)
+		n.traceEvent(TraceDropDupe, e, nil)
+		go n.recordDuplicate(e)
 		return
 	}
+	n.pushToLightSubscribers(e)
 	if receivers, ok := n.subscribers[*e.topicDigest]; ok {
 		n.log.eventDeliver(n, e)
 		n.deliver(receivers, e)
 		e.publishers = append(e.publishers, id)
-		for _, peerId := range n.net.connectedPeers() {
+		peers := n.net.connectedPeers()
+		n.mutex.RUnlock()
+		n.traceEvent(TraceDeliver, e, nil)
+		n.signEvent(e) // re-sign as this hop before relaying onward
+		for _, peerId := range peers {
 			go n.net.sendEvent(peerId, e)
 			go n.log.eventRouteDirect(n, e, peerId)
+			n.traceEvent(TraceRouteDirect, e, peerId)
 		}
-		n.mutex.RUnlock()
 		return
 	}
 	e.ttl -= 1
 	if e.ttl == 0 {
-		go n.log.eventDropTTL(n, e)
 		n.mutex.RUnlock()
+		go n.log.eventDropTTL(n, e)
+		n.traceEvent(TraceDropTTL, e, nil)
 		return
 	}
 	for i := 0; uint32(i) < n.cfg.FiltersDepth; i++ {
+		candidates := []*pubkey{}
 		for peerId, data := range n.peers {
+			peerId := peerId
 			f := data.filters[i]
 			if filterContainsDigest(f, n.cfg, *e.topicDigest) {
 				negRt := false
@@ -170,42 +220,94 @@ func (n *Node) route(e *event) {
 					}
 				}
 				if !negRt {
-					go n.net.sendEvent(&peerId, e)
-					go n.log.eventRouteWell(n, e, &peerId)
-					n.mutex.RUnlock()
-					return
+					candidates = append(candidates, &peerId)
 				}
 			}
 		}
+		if len(candidates) > 0 {
+			chosen := n.pickWeighted(candidates)
+			n.mutex.RUnlock()
+			n.signEvent(e) // re-sign as this hop before relaying onward
+			go n.net.sendEvent(chosen, e)
+			go n.log.eventRouteWell(n, e, chosen)
+			n.traceEvent(TraceRouteWell, e, chosen)
+			go n.recordRouteWell(chosen, e)
+			return
+		}
 	}
 	peerId := n.randomPeer()
+	n.mutex.RUnlock()
 	if peerId != nil {
+		n.signEvent(e) // re-sign as this hop before relaying onward
 		go n.net.sendEvent(peerId, e)
 		go n.log.eventRouteRandom(n, e, peerId)
+		n.traceEvent(TraceRouteRandom, e, peerId)
 	}
-	n.mutex.RUnlock()
 }
 
+// randomPeer picks a connected peer, biased towards peers with a
+// higher score (see pickWeighted) rather than picking uniformly.
 func (n *Node) randomPeer() *pubkey {
 	peers := n.net.connectedPeers()
 	if len(peers) == 0 {
 		return nil
 	}
-	return peers[rand.Intn(len(peers))]
+	return n.pickWeighted(peers)
 }
 
 func (n *Node) dispatchInput() {
 	for {
 		select {
 		case peerUpdate := <-n.net.receivedUpdateChannel():
+			if !n.authenticateUpdate(peerUpdate) {
+				if peerUpdate != nil {
+					n.traceUpdate(TraceDropAuth, peerUpdate.index, peerUpdate.peer)
+				}
+				continue
+			}
 			if validUpdate(peerUpdate, n.cfg) {
+				if peerUpdate.peer == nil || !n.limiter.allowUpdate(*peerUpdate.peer) {
+					go n.log.updateFail(n, peerUpdate)
+					n.traceUpdate(TraceUpdateFail, peerUpdate.index, peerUpdate.peer)
+					continue
+				}
 				go n.processUpdate(peerUpdate)
 			}
 		case event := <-n.net.receivedEventChannel():
+			if !n.authenticateEvent(event) {
+				sender := eventSender(event)
+				n.traceEvent(TraceDropAuth, event, &sender)
+				continue
+			}
 			if validEvent(event) {
+				sender := eventSender(event)
+				if !n.limiter.allowEvent(sender) {
+					go n.log.eventDropRateLimit(n, event, &sender)
+					n.traceEvent(TraceDropRateLimit, event, &sender)
+					continue
+				}
 				go n.log.eventReceived(n, event)
 				go n.route(event)
 			}
+		case req := <-n.net.receivedSubscribeRequestChannel():
+			if req == nil || req.requester == nil || !n.limiter.allowControl(*req.requester) {
+				continue
+			}
+			go n.handleSubscribeRequest(req)
+		case push := <-n.net.receivedFilterPushChannel():
+			sender := unknownEventSender
+			if push != nil && push.sender != nil {
+				sender = *push.sender
+			}
+			if !n.limiter.allowEvent(sender) {
+				continue
+			}
+			go n.handleFilterPush(push)
+		case sc := <-n.net.receivedSubscriptionChangeChannel():
+			if sc == nil || sc.peer == nil || !n.limiter.allowControl(*sc.peer) {
+				continue
+			}
+			go n.handleSubscriptionChange(sc)
 		case <-n.stopDispatcher:
 			return
 		}
@@ -224,6 +326,9 @@ func (n *Node) removeExpiredPeers() {
 		delete(n.peers, *peerId)
 	}
 	n.mutex.Unlock()
+	n.limiter.gc(n.cfg.FilterFreshness)
+	n.removeExpiredLightSubs()
+	n.removeExpiredRoutes()
 }
 
 func (n *Node) expiredPeerGC() {
@@ -253,6 +358,12 @@ func (n *Node) propagateFilters() {
 // Start quasar system
 func (n *Node) Start() {
 	n.net.start()
+	n.mutex.RLock()
+	codec := n.codec
+	n.mutex.RUnlock()
+	if cr, ok := n.net.(codecReceiver); ok {
+		cr.SetCodec(codec)
+	}
 	n.stopDispatcher = make(chan bool)
 	n.stopPropagation = make(chan bool)
 	n.stopExpiredPeerGC = make(chan bool)
@@ -282,6 +393,11 @@ func (n *Node) Subscribe(topic []byte, receiver io.Writer) {
 		n.subscribers[digest] = append(receivers, receiver)
 	}
 	n.mutex.Unlock()
+	if n.cfg.LightMode {
+		go n.sendSubscribeRequest(digest, false)
+	} else if !ok { // first local subscriber for this topic
+		go n.floodSubscriptionChange(digest, true)
+	}
 }
 
 // Unsubscribe message receiver channel from topic. If nil receiver
@@ -307,11 +423,20 @@ func (n *Node) Unsubscribe(topic []byte, receiver io.Writer) {
 
 	// remove sub key if no specific message
 	// receiver provided or no message receiver remaining
+	stillSubscribed := true
 	if ok && (receiver == nil || len(n.subscribers[digest]) == 0) {
 		delete(n.subscribers, digest)
 		delete(n.topics, digest)
+		stillSubscribed = false
 	}
 	n.mutex.Unlock()
+	if !stillSubscribed {
+		if n.cfg.LightMode {
+			go n.sendSubscribeRequest(digest, true)
+		} else {
+			go n.floodSubscriptionChange(digest, false)
+		}
+	}
 }
 
 // Subscribers retruns message receivers for given topic.
@@ -327,6 +452,25 @@ func (n *Node) Subscribers(topic []byte) []io.Writer {
 	return results
 }
 
+// SetTracer attaches a Tracer that receives a TraceEvent at the publish,
+// deliver, drop, route, update and subscription-change call sites.
+// Received events (Logger.EventsReceived) are logged but not traced.
+// Pass nil to disable tracing.
+func (n *Node) SetTracer(t Tracer) {
+	n.mutex.Lock()
+	n.tracer = t
+	n.mutex.Unlock()
+}
+
+// SetCodec overrides the wire format used to encode/decode updates and
+// events, e.g. to plug in protobuf or CBOR instead of the built-in
+// length-prefixed binary format. Must be called before Start().
+func (n *Node) SetCodec(c Codec) {
+	n.mutex.Lock()
+	n.codec = c
+	n.mutex.Unlock()
+}
+
 // Subscriptions retruns a slice of currently subscribed topics.
 func (n *Node) Subscriptions() [][]byte {
 	n.mutex.RLock()
@@ -0,0 +1,100 @@
+package quasar
+
+// fakeOverlay is a minimal in-memory networkOverlay test double: it lets
+// tests drive Node's dispatcher and lifecycle methods without a real
+// transport. sendX calls are no-ops unless the target peer has been
+// wired with link, in which case they deliver onto that peer's received
+// channels the way a real networkOverlay would.
+type fakeOverlay struct {
+	self      pubkey
+	connected map[pubkey]bool
+	peers     map[pubkey]*fakeOverlay
+	codec     Codec // captured if SetCodec is called, e.g. by Node.Start()
+
+	updates             chan *update
+	events              chan *event
+	subscribeRequests   chan *subscribeRequest
+	filterPushes        chan *filterPush
+	subscriptionChanges chan *subscriptionChange
+}
+
+func newFakeOverlay(self pubkey) *fakeOverlay {
+	return &fakeOverlay{
+		self:                self,
+		connected:           make(map[pubkey]bool),
+		peers:               make(map[pubkey]*fakeOverlay),
+		updates:             make(chan *update, 4096),
+		events:              make(chan *event, 4096),
+		subscribeRequests:   make(chan *subscribeRequest, 4096),
+		filterPushes:        make(chan *filterPush, 4096),
+		subscriptionChanges: make(chan *subscriptionChange, 4096),
+	}
+}
+
+// link wires f and other as mutually connected peers, so sendUpdate/
+// sendEvent/etc. on one actually deliver onto the other's received
+// channels.
+func (f *fakeOverlay) link(other *fakeOverlay) {
+	f.connected[other.self] = true
+	f.peers[other.self] = other
+	other.connected[f.self] = true
+	other.peers[f.self] = f
+}
+
+func (f *fakeOverlay) SetCodec(c Codec) { f.codec = c }
+
+func (f *fakeOverlay) id() pubkey                 { return f.self }
+func (f *fakeOverlay) isConnected(p *pubkey) bool { return p != nil && f.connected[*p] }
+func (f *fakeOverlay) connectedPeers() []*pubkey {
+	ids := make([]*pubkey, 0, len(f.peers))
+	for p := range f.peers {
+		p := p
+		ids = append(ids, &p)
+	}
+	return ids
+}
+
+func (f *fakeOverlay) sendUpdate(p *pubkey, index uint32, filter []byte) {
+	if peer, ok := f.peers[*p]; ok {
+		peer.updates <- &update{peer: &f.self, index: index, filter: filter}
+	}
+}
+
+func (f *fakeOverlay) sendEvent(p *pubkey, e *event) {
+	if peer, ok := f.peers[*p]; ok {
+		cp := *e
+		cp.hopSender = &f.self // attribute the hop the way a real overlay would
+		peer.events <- &cp
+	}
+}
+
+func (f *fakeOverlay) sendSubscriptionChange(p *pubkey, sc *subscriptionChange) {
+	if peer, ok := f.peers[*p]; ok {
+		peer.subscriptionChanges <- sc
+	}
+}
+
+func (f *fakeOverlay) sendSubscribeRequest(p *pubkey, req *subscribeRequest) {
+	if peer, ok := f.peers[*p]; ok {
+		peer.subscribeRequests <- req
+	}
+}
+
+func (f *fakeOverlay) sendFilterPush(p *pubkey, push *filterPush) {
+	if peer, ok := f.peers[*p]; ok {
+		peer.filterPushes <- push
+	}
+}
+
+func (f *fakeOverlay) receivedUpdateChannel() <-chan *update             { return f.updates }
+func (f *fakeOverlay) receivedEventChannel() <-chan *event               { return f.events }
+func (f *fakeOverlay) receivedSubscribeRequestChannel() <-chan *subscribeRequest {
+	return f.subscribeRequests
+}
+func (f *fakeOverlay) receivedFilterPushChannel() <-chan *filterPush { return f.filterPushes }
+func (f *fakeOverlay) receivedSubscriptionChangeChannel() <-chan *subscriptionChange {
+	return f.subscriptionChanges
+}
+
+func (f *fakeOverlay) start() {}
+func (f *fakeOverlay) stop()  {}
@@ -0,0 +1,133 @@
+package quasar
+
+import "sync"
+
+// tokenBucket is a classic token-bucket limiter: tokens refill at a
+// fixed rate up to a burst ceiling, and each allowed call consumes one.
+type tokenBucket struct {
+	tokens   float64
+	max      float64
+	rate     float64 // tokens added per second
+	lastSeen uint64  // makePeerTimestamp() of the last refill
+}
+
+func newTokenBucket(rate, burst float64, now uint64) *tokenBucket {
+	return &tokenBucket{tokens: burst, max: burst, rate: rate, lastSeen: now}
+}
+
+func (b *tokenBucket) allow(now uint64) bool {
+	if now > b.lastSeen {
+		b.tokens += float64(now-b.lastSeen) / 1000.0 * b.rate
+		if b.tokens > b.max {
+			b.tokens = b.max
+		}
+		b.lastSeen = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// peerLimiter holds the independent update/event/control token buckets
+// for one peer, plus the last time any of them was touched so gc can
+// evict peers that have gone quiet. controls covers subscribeRequest and
+// subscriptionChange messages, which otherwise spawn an unbounded
+// goroutine per message with no rate limiting of their own.
+type peerLimiter struct {
+	updates  *tokenBucket
+	events   *tokenBucket
+	controls *tokenBucket
+	lastSeen uint64
+}
+
+// rateLimiter gates inbound updates and events per sender pubkey,
+// following the token-bucket design used by wireguard-go's
+// ratelimiter.go: a single mutex-guarded map keyed by pubkey, with a
+// periodic sweep evicting peers unseen for a configurable window.
+type rateLimiter struct {
+	mutex sync.Mutex
+	peers map[pubkey]*peerLimiter
+	cfg   *Config
+}
+
+func newRateLimiter(c *Config) *rateLimiter {
+	return &rateLimiter{peers: make(map[pubkey]*peerLimiter), cfg: c}
+}
+
+func (r *rateLimiter) limiterFor(p pubkey, now uint64) *peerLimiter {
+	pl, ok := r.peers[p]
+	if !ok {
+		pl = &peerLimiter{
+			updates:  newTokenBucket(r.cfg.UpdatesPerSecond, float64(r.cfg.UpdatesBurst), now),
+			events:   newTokenBucket(r.cfg.EventsPerSecond, float64(r.cfg.EventsBurst), now),
+			controls: newTokenBucket(r.cfg.ControlsPerSecond, float64(r.cfg.ControlsBurst), now),
+		}
+		r.peers[p] = pl
+	}
+	pl.lastSeen = now
+	return pl
+}
+
+// allowUpdate reports whether an update from p is within its token
+// budget, consuming a token if so.
+func (r *rateLimiter) allowUpdate(p pubkey) bool {
+	now := makePeerTimestamp()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.limiterFor(p, now).updates.allow(now)
+}
+
+// allowEvent reports whether an event attributed to p is within its
+// token budget, consuming a token if so.
+func (r *rateLimiter) allowEvent(p pubkey) bool {
+	now := makePeerTimestamp()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.limiterFor(p, now).events.allow(now)
+}
+
+// allowControl reports whether a subscribeRequest or subscriptionChange
+// from p is within its token budget, consuming a token if so.
+func (r *rateLimiter) allowControl(p pubkey) bool {
+	now := makePeerTimestamp()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.limiterFor(p, now).controls.allow(now)
+}
+
+// gc evicts peers that have not sent an update, event or control
+// message in the last window ms, so a flood of short-lived or spoofed peer keys doesn't
+// grow the map without bound.
+func (r *rateLimiter) gc(window uint64) {
+	now := makePeerTimestamp()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for p, pl := range r.peers {
+		if now-pl.lastSeen > window {
+			delete(r.peers, p)
+		}
+	}
+}
+
+// unknownEventSender is the bucket shared by inbound events that arrive
+// without hop attribution (e.g. a test-constructed event that bypasses
+// the overlay entirely). It still bounds the aggregate rate of
+// unattributed events even though it can't single out which peer sent
+// any one of them.
+var unknownEventSender pubkey
+
+// eventSender returns the peer that most recently forwarded e to this
+// node: e.hopSender, attributed by the overlay at delivery time. This is
+// distinct from e.publishers, the app-level anti-loop chain used by
+// route() to avoid sending an event back towards a peer that already
+// has it — publishers is rarely populated for well/random-routed
+// relays, so it cannot double as a reliable sender identity. Returns
+// unknownEventSender if e hasn't been attributed yet.
+func eventSender(e *event) pubkey {
+	if e == nil || e.hopSender == nil {
+		return unknownEventSender
+	}
+	return *e.hopSender
+}
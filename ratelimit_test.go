@@ -0,0 +1,130 @@
+package quasar
+
+import (
+	"testing"
+	"time"
+)
+
+func testLimiterConfig() *Config {
+	c := StandardConfig
+	c.UpdatesPerSecond = 10
+	c.UpdatesBurst = 5
+	c.EventsPerSecond = 10
+	c.EventsBurst = 5
+	return &c
+}
+
+func TestRateLimiterAllowsUpToBurstThenRejects(t *testing.T) {
+	r := newRateLimiter(testLimiterConfig())
+	var p pubkey
+	copy(p[:], []byte("flooding-peer-aaaaaaaaaaaaaaaaaa"))
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if r.allowUpdate(p) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("expected all %d burst tokens to be allowed, got %d", 5, allowed)
+	}
+	if r.allowUpdate(p) {
+		t.Error("expected update beyond burst to be rejected")
+	}
+}
+
+// TestRateLimiterFloodStaysBounded simulates a single hostile peer
+// sending far more updates than dispatchInput would otherwise spawn
+// goroutines for, and checks the limiter's own state stays O(1) in the
+// number of peers regardless of how many updates that one peer sends.
+func TestRateLimiterFloodStaysBounded(t *testing.T) {
+	r := newRateLimiter(testLimiterConfig())
+	var attacker pubkey
+	copy(attacker[:], []byte("hostile-peer-aaaaaaaaaaaaaaaaaaa"))
+
+	rejected := 0
+	for i := 0; i < 100000; i++ {
+		if !r.allowUpdate(attacker) {
+			rejected++
+		}
+	}
+	if rejected == 0 {
+		t.Error("expected a flood from one peer to be rate limited")
+	}
+	if len(r.peers) != 1 {
+		t.Fatalf("expected exactly one tracked peer, got %d", len(r.peers))
+	}
+}
+
+func TestRateLimiterGCEvictsStalePeers(t *testing.T) {
+	r := newRateLimiter(testLimiterConfig())
+	var p pubkey
+	copy(p[:], []byte("stale-peer-aaaaaaaaaaaaaaaaaaaaaa"))
+	r.allowUpdate(p)
+	r.peers[p].lastSeen = 0 // simulate a peer not seen since the epoch
+
+	r.gc(1000)
+	if _, ok := r.peers[p]; ok {
+		t.Error("expected gc to evict a peer unseen for longer than the window")
+	}
+}
+
+// TestDispatchInputRateLimitsUpdateFlood drives an actual Node's
+// dispatchInput goroutine over a fake networkOverlay and floods it with
+// updates from one peer, checking the rate limiter's own state stays
+// bounded to that one peer rather than trusting only the bare
+// rateLimiter type in isolation (see TestRateLimiterFloodStaysBounded).
+func TestDispatchInputRateLimitsUpdateFlood(t *testing.T) {
+	c := testLimiterConfig()
+	c.FiltersDepth = 2
+	c.FiltersM = 8
+
+	var self, attacker pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	copy(attacker[:], []byte("hostile-peer-aaaaaaaaaaaaaaaaaaa"))
+
+	fo := newFakeOverlay(self)
+	fo.connected[attacker] = true
+
+	n := newNode(fo, nil, c)
+	n.stopDispatcher = make(chan bool)
+	go n.dispatchInput()
+	defer func() { n.stopDispatcher <- true }()
+
+	filter := make([]byte, c.FiltersM/8)
+	const flood = 2000
+	for i := 0; i < flood; i++ {
+		fo.updates <- &update{peer: &attacker, index: 0, filter: filter}
+	}
+
+	for deadline := time.Now().Add(time.Second); len(fo.updates) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // let the last allowed goroutines finish applying
+
+	n.limiter.mutex.Lock()
+	tracked := len(n.limiter.peers)
+	n.limiter.mutex.Unlock()
+	if tracked != 1 {
+		t.Fatalf("expected exactly one rate-limited peer tracked by the dispatcher, got %d", tracked)
+	}
+
+	stats := n.PeerStats()
+	if _, ok := stats[attacker]; !ok {
+		t.Error("expected at least one flooded update to have been allowed through and applied")
+	}
+}
+
+func TestEventSenderFallsBackToUnknown(t *testing.T) {
+	e := &event{}
+	if got := eventSender(e); got != unknownEventSender {
+		t.Errorf("expected unattributed event to use unknownEventSender, got %v", got)
+	}
+
+	var p pubkey
+	copy(p[:], []byte("last-hop-peer-aaaaaaaaaaaaaaaaaa"))
+	e.hopSender = &p
+	if got := eventSender(e); got != p {
+		t.Errorf("expected eventSender to return the attributed hop sender, got %v want %v", got, p)
+	}
+}
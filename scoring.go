@@ -0,0 +1,209 @@
+package quasar
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// scoreDecayHalfLife is how long it takes a stale peerScore to decay
+// halfway back towards its neutral baseline (fully-applied, nothing wasted).
+const scoreDecayHalfLife = 10 * time.Minute
+
+// scoreEWMAAlpha weights how much a single new sample moves the running
+// average; higher reacts faster, lower smooths out noise.
+const scoreEWMAAlpha = 0.2
+
+// routedEventWindow is how long a well-routed event is remembered while
+// waiting to see whether it comes back as a duplicate.
+const routedEventWindow = 30 * time.Second
+
+// peerScore tracks how well a peer has been behaving: whether its
+// updates get applied, how quickly propagation rounds arrive from it,
+// and how often events well-routed to it turn out to have been wasted
+// because the peer already had them.
+type peerScore struct {
+	appliedOK   float64 // EWMA, 1 = update applied, 0 = rejected
+	wasted      float64 // EWMA, 1 = a routed event bounced back as a duplicate
+	avgLatency  float64 // EWMA of ms between received propagation rounds
+	lastRoundAt uint64  // makePeerTimestamp() of the last received update
+	updatedAt   uint64  // makePeerTimestamp() this score was last touched, for decay
+}
+
+func newPeerScore() *peerScore {
+	return &peerScore{appliedOK: 1, updatedAt: makePeerTimestamp()}
+}
+
+func ewma(prev, sample, alpha float64) float64 {
+	return prev + alpha*(sample-prev)
+}
+
+// decay pulls appliedOK and wasted back towards their neutral values (1
+// and 0) the longer a peer has gone unseen, so a score from an old burst
+// of activity doesn't stay frozen forever.
+func (s *peerScore) decay(now uint64) {
+	if now <= s.updatedAt {
+		return
+	}
+	elapsed := time.Duration(now-s.updatedAt) * time.Millisecond
+	factor := math.Pow(0.5, float64(elapsed)/float64(scoreDecayHalfLife))
+	s.appliedOK = 1 - (1-s.appliedOK)*factor
+	s.wasted = s.wasted * factor
+	s.updatedAt = now
+}
+
+// value combines the tracked signals into a single weight in (0, 1],
+// higher is better: peers that apply updates, respond quickly, and
+// don't waste bandwidth on duplicates score higher. It is never fully
+// zero so a poorly-scored peer can still recover.
+func (s *peerScore) value() float64 {
+	latencyFactor := 1.0
+	if s.avgLatency > 0 {
+		latencyFactor = 1000.0 / (1000.0 + s.avgLatency)
+	}
+	v := 0.6*s.appliedOK + 0.2*latencyFactor + 0.2*(1-s.wasted)
+	if v < 0.01 {
+		return 0.01
+	}
+	return v
+}
+
+// routedEvent remembers which peer a well-routed event was last sent
+// to, so a later duplicate of the same event can be charged against it.
+type routedEvent struct {
+	peer *pubkey
+	at   uint64
+}
+
+// eventScoreKey identifies an event for wasted-work tracking the same
+// way isDuplicate does: by its topic digest and message bytes.
+func eventScoreKey(e *event) string {
+	return string(e.topicDigest[:20]) + string(e.message)
+}
+
+// PeerStat is a snapshot of a peer's reputation as seen by this Node.
+type PeerStat struct {
+	AppliedRatio float64 // fraction of recent updates that were applied rather than rejected
+	WastedRatio  float64 // fraction of recent well-routed events that bounced back as duplicates
+	AvgLatencyMS float64 // average ms between propagation rounds received from this peer
+	Score        float64 // combined weight used for routing selection
+}
+
+// PeerStats returns a reputation snapshot for every peer this Node has
+// scored, after applying decay for time elapsed since their last update.
+func (n *Node) PeerStats() map[pubkey]PeerStat {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	now := makePeerTimestamp()
+	stats := make(map[pubkey]PeerStat, len(n.scores))
+	for p, s := range n.scores {
+		s.decay(now)
+		stats[p] = PeerStat{
+			AppliedRatio: s.appliedOK,
+			WastedRatio:  s.wasted,
+			AvgLatencyMS: s.avgLatency,
+			Score:        s.value(),
+		}
+	}
+	return stats
+}
+
+// score returns (creating if necessary) the peerScore for p. Callers
+// must hold n.mutex for writing.
+func (n *Node) score(p *pubkey) *peerScore {
+	s, ok := n.scores[*p]
+	if !ok {
+		s = newPeerScore()
+		n.scores[*p] = s
+	}
+	return s
+}
+
+// recordUpdateApplied scores whether a peerUpdate from p was applied or
+// rejected, and updates the propagation-round latency estimate.
+func (n *Node) recordUpdateApplied(p *pubkey, applied bool) {
+	n.mutex.Lock()
+	now := makePeerTimestamp()
+	s := n.score(p)
+	s.decay(now)
+	sample := 0.0
+	if applied {
+		sample = 1.0
+	}
+	s.appliedOK = ewma(s.appliedOK, sample, scoreEWMAAlpha)
+	if s.lastRoundAt != 0 && now > s.lastRoundAt {
+		s.avgLatency = ewma(s.avgLatency, float64(now-s.lastRoundAt), scoreEWMAAlpha)
+	}
+	s.lastRoundAt = now
+	s.updatedAt = now
+	n.mutex.Unlock()
+}
+
+// recordRouteWell remembers that e was well-routed to p, so a later
+// duplicate of e can be charged against p's score as wasted work.
+func (n *Node) recordRouteWell(p *pubkey, e *event) {
+	n.mutex.Lock()
+	n.recentRoutes[eventScoreKey(e)] = routedEvent{peer: p, at: makePeerTimestamp()}
+	n.mutex.Unlock()
+}
+
+// recordDuplicate charges the peer e was last well-routed to, if any
+// and still within routedEventWindow, for wasted work.
+func (n *Node) recordDuplicate(e *event) {
+	n.mutex.Lock()
+	key := eventScoreKey(e)
+	if re, ok := n.recentRoutes[key]; ok {
+		delete(n.recentRoutes, key)
+		now := makePeerTimestamp()
+		if now-re.at < uint64(routedEventWindow/time.Millisecond) {
+			s := n.score(re.peer)
+			s.decay(now)
+			s.wasted = ewma(s.wasted, 1, scoreEWMAAlpha)
+			s.updatedAt = now
+		}
+	}
+	n.mutex.Unlock()
+}
+
+// removeExpiredRoutes evicts recentRoutes entries older than
+// routedEventWindow: once a well-routed event is too old to still
+// charge recordDuplicate against its peer, keeping it around only grows
+// the map without bound for events that never loop back as a duplicate.
+func (n *Node) removeExpiredRoutes() {
+	n.mutex.Lock()
+	now := makePeerTimestamp()
+	window := uint64(routedEventWindow / time.Millisecond)
+	for key, re := range n.recentRoutes {
+		if now-re.at > window {
+			delete(n.recentRoutes, key)
+		}
+	}
+	n.mutex.Unlock()
+}
+
+// pickWeighted selects one of candidates at random, weighted by each
+// peer's current score so better-behaved peers are favored without
+// starving out everyone else. Callers must hold n.mutex.
+func (n *Node) pickWeighted(candidates []*pubkey) *pubkey {
+	if len(candidates) == 0 {
+		return nil
+	}
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, p := range candidates {
+		w := 1.0
+		if s, ok := n.scores[*p]; ok {
+			w = s.value()
+		}
+		weights[i] = w
+		total += w
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,170 @@
+package quasar
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayMovesScoreTowardsNeutral(t *testing.T) {
+	s := &peerScore{appliedOK: 0, wasted: 1, updatedAt: 0}
+	now := uint64(scoreDecayHalfLife / time.Millisecond)
+
+	s.decay(now)
+
+	if math.Abs(s.appliedOK-0.5) > 0.0001 {
+		t.Errorf("expected appliedOK to move halfway to its neutral value 1 after one half-life, got %v", s.appliedOK)
+	}
+	if math.Abs(s.wasted-0.5) > 0.0001 {
+		t.Errorf("expected wasted to move halfway to its neutral value 0 after one half-life, got %v", s.wasted)
+	}
+	if s.updatedAt != now {
+		t.Error("expected decay to advance updatedAt to now")
+	}
+}
+
+func TestDecayFullyNeutralizesOverManyHalfLives(t *testing.T) {
+	s := &peerScore{appliedOK: 0, wasted: 1, updatedAt: 0}
+	now := uint64(10 * scoreDecayHalfLife / time.Millisecond)
+
+	s.decay(now)
+
+	if s.appliedOK < 0.999 {
+		t.Errorf("expected appliedOK to have decayed almost fully back to 1, got %v", s.appliedOK)
+	}
+	if s.wasted > 0.001 {
+		t.Errorf("expected wasted to have decayed almost fully back to 0, got %v", s.wasted)
+	}
+}
+
+func TestDecayIsNoOpWhenNotStale(t *testing.T) {
+	s := &peerScore{appliedOK: 0.4, wasted: 0.3, updatedAt: 1000}
+
+	s.decay(1000)
+
+	if s.appliedOK != 0.4 || s.wasted != 0.3 {
+		t.Error("expected decay to leave the score untouched when now <= updatedAt")
+	}
+}
+
+func TestRecordUpdateAppliedMovesAppliedOKTowardsSample(t *testing.T) {
+	var self, p pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	copy(p[:], []byte("reporting-peer-aaaaaaaaaaaaaaaaaa"))
+	n := newNode(newFakeOverlay(self), nil, &StandardConfig)
+
+	n.recordUpdateApplied(&p, false)
+
+	n.mutex.RLock()
+	s, ok := n.scores[p]
+	n.mutex.RUnlock()
+	if !ok {
+		t.Fatal("expected recordUpdateApplied to create a peerScore for p")
+	}
+	if math.Abs(s.appliedOK-0.8) > 0.0001 {
+		t.Errorf("expected a rejected update to pull appliedOK from its initial 1 towards 0 by scoreEWMAAlpha, got %v", s.appliedOK)
+	}
+
+	n.recordUpdateApplied(&p, true)
+
+	n.mutex.RLock()
+	after := n.scores[p].appliedOK
+	n.mutex.RUnlock()
+	if after <= 0.8 {
+		t.Errorf("expected an applied update to move appliedOK back up towards 1, got %v", after)
+	}
+}
+
+func TestRecordDuplicateChargesWastedAgainstRecentlyRoutedPeer(t *testing.T) {
+	var self, p pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	copy(p[:], []byte("routed-to-peer-aaaaaaaaaaaaaaaaaa"))
+	n := newNode(newFakeOverlay(self), nil, &StandardConfig)
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, message: []byte("hi")}
+
+	n.recordRouteWell(&p, e)
+	n.recordDuplicate(e)
+
+	n.mutex.RLock()
+	s, ok := n.scores[p]
+	n.mutex.RUnlock()
+	if !ok {
+		t.Fatal("expected recordDuplicate to score the peer e was well-routed to")
+	}
+	if math.Abs(s.wasted-scoreEWMAAlpha) > 0.0001 {
+		t.Errorf("expected the first duplicate to pull wasted from 0 towards 1 by scoreEWMAAlpha, got %v", s.wasted)
+	}
+}
+
+func TestRecordDuplicateIsNoOpWithoutAMatchingRoute(t *testing.T) {
+	var self pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	n := newNode(newFakeOverlay(self), nil, &StandardConfig)
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, message: []byte("never routed")}
+
+	n.recordDuplicate(e)
+
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	if len(n.scores) != 0 {
+		t.Error("expected recordDuplicate to score nothing when the event was never recorded as well-routed")
+	}
+}
+
+// TestPickWeightedFavorsHigherScoredPeer drives pickWeighted many times
+// over two candidates with deliberately lopsided scores and checks the
+// better-scored peer is picked far more often, not just at the ~50/50
+// rate a plain uniform pick would give.
+func TestPickWeightedFavorsHigherScoredPeer(t *testing.T) {
+	var self, good, bad pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	copy(good[:], []byte("well-behaved-peer-aaaaaaaaaaaaaaa"))
+	copy(bad[:], []byte("poorly-behaved-peer-aaaaaaaaaaaaa"))
+	n := newNode(newFakeOverlay(self), nil, &StandardConfig)
+
+	n.mutex.Lock()
+	n.scores[good] = &peerScore{appliedOK: 1, wasted: 0}
+	n.scores[bad] = &peerScore{appliedOK: 0, wasted: 1}
+	n.mutex.Unlock()
+
+	candidates := []*pubkey{&good, &bad}
+	goodCount, badCount := 0, 0
+	n.mutex.RLock()
+	for i := 0; i < 2000; i++ {
+		switch *n.pickWeighted(candidates) {
+		case good:
+			goodCount++
+		case bad:
+			badCount++
+		}
+	}
+	n.mutex.RUnlock()
+
+	if goodCount <= badCount*2 {
+		t.Errorf("expected pickWeighted to strongly favor the higher-scored peer, got good=%d bad=%d", goodCount, badCount)
+	}
+}
+
+func TestRemoveExpiredRoutesEvictsStaleEntries(t *testing.T) {
+	var self pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	n := newNode(newFakeOverlay(self), nil, &StandardConfig)
+
+	var p pubkey
+	copy(p[:], []byte("routed-to-peer-aaaaaaaaaaaaaaaaaa"))
+	n.recentRoutes["stale-key"] = routedEvent{peer: &p, at: 0}
+	n.recentRoutes["fresh-key"] = routedEvent{peer: &p, at: makePeerTimestamp()}
+
+	n.removeExpiredRoutes()
+
+	if _, ok := n.recentRoutes["stale-key"]; ok {
+		t.Error("expected an entry older than routedEventWindow to be evicted")
+	}
+	if _, ok := n.recentRoutes["fresh-key"]; !ok {
+		t.Error("expected a recent entry to survive the sweep")
+	}
+}
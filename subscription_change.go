@@ -0,0 +1,115 @@
+package quasar
+
+import "encoding/binary"
+
+// Peer event kinds delivered on Node.PeerEvents().
+const (
+	PeerJoined = "JOINED"
+	PeerLeft   = "LEFT"
+)
+
+// subscriptionChange is an eager, one-hop notification that a peer has
+// joined or left a topic, sent by Subscribe/Unsubscribe so neighbours
+// don't have to wait a full PropagationDelay round to learn about it.
+type subscriptionChange struct {
+	peer        *pubkey
+	topicDigest hash160digest
+	join        bool
+	nonce       uint64 // makePeerTimestamp() at origination, for dejavu dedup
+	signature   []byte // Ed25519 signature over subscriptionChangeKey(sc)
+}
+
+func subscriptionChangeKey(sc *subscriptionChange) []byte {
+	key := make([]byte, 0, pubkeySize+hash160Size+1+8)
+	key = append(key, sc.peer[:]...)
+	key = append(key, sc.topicDigest[:]...)
+	if sc.join {
+		key = append(key, 1)
+	} else {
+		key = append(key, 0)
+	}
+	var nonceBuf [8]byte
+	binary.BigEndian.PutUint64(nonceBuf[:], sc.nonce)
+	return append(key, nonceBuf[:]...)
+}
+
+// PeerEvent reports that a peer has joined or left a topic, as learned
+// from a subscriptionChange notification.
+type PeerEvent struct {
+	Peer        pubkey
+	TopicDigest hash160digest
+	Kind        string // PeerJoined or PeerLeft
+}
+
+// PeerEvents returns a channel of PeerEvents so applications can react
+// to a peer joining/leaving a topic. Events are dropped rather than
+// blocking the dispatcher if the channel isn't drained.
+func (n *Node) PeerEvents() <-chan PeerEvent {
+	return n.peerEvents
+}
+
+func (n *Node) emitPeerEvent(peer pubkey, digest hash160digest, join bool) {
+	kind := PeerLeft
+	if join {
+		kind = PeerJoined
+	}
+	select {
+	case n.peerEvents <- PeerEvent{Peer: peer, TopicDigest: digest, Kind: kind}:
+	default: // drop if nobody is listening/keeping up
+	}
+}
+
+// floodSubscriptionChange notifies connectedPeers() that this node
+// joined or left digest, one hop, so they can update their cached
+// filters[0] for this node immediately instead of waiting on the next
+// propagation round.
+func (n *Node) floodSubscriptionChange(digest hash160digest, join bool) {
+	id := n.net.id()
+	sc := &subscriptionChange{
+		peer:        &id,
+		topicDigest: digest,
+		join:        join,
+		nonce:       makePeerTimestamp(),
+	}
+	n.signSubscriptionChange(sc)
+	n.scHistory.Witness(subscriptionChangeKey(sc)) // don't reprocess our own flood if it loops back
+	for _, peerId := range n.net.connectedPeers() {
+		go n.net.sendSubscriptionChange(peerId, sc)
+	}
+}
+
+// handleSubscriptionChange applies a received subscriptionChange: it
+// updates the sender's cached filters[0] so route() can find it without
+// waiting a full propagation cycle, logs/traces the change, and emits a
+// PeerEvent.
+func (n *Node) handleSubscriptionChange(sc *subscriptionChange) {
+	if sc == nil || sc.peer == nil || !n.authenticateSubscriptionChange(sc) {
+		return
+	}
+	if n.scHistory.Witness(subscriptionChangeKey(sc)) {
+		return // already applied this exact change
+	}
+
+	n.mutex.Lock()
+	data, ok := n.peers[*sc.peer]
+	if !ok {
+		depth := n.cfg.FiltersDepth
+		data = &peerData{
+			filters:    newFilters(n.cfg),
+			timestamps: make([]uint64, depth, depth),
+		}
+		n.peers[*sc.peer] = data
+	}
+	if sc.join {
+		addFilterDigest(data.filters[0], n.cfg, sc.topicDigest)
+	}
+	// Leaving a topic just lets it fall out of the bloom filter on the
+	// next full propagation round: clearing a single bit isn't safe
+	// when other subscriptions may share it.
+	data.timestamps[0] = makePeerTimestamp()
+	n.mutex.Unlock()
+
+	go n.log.subscriptionChange(n, sc.peer, sc.topicDigest, sc.join)
+	n.traceSubscriptionChange(sc)
+	n.emitPeerEvent(*sc.peer, sc.topicDigest, sc.join)
+}
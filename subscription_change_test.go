@@ -0,0 +1,93 @@
+package quasar
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func testSubscriptionChangeConfig() *Config {
+	c := StandardConfig
+	c.RequireSignatures = true
+	return &c
+}
+
+func TestHandleSubscriptionChangeRejectsSpoofedPeer(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	spoofedPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var spoofedPeer pubkey
+	copy(spoofedPeer[:], spoofedPub) // claims to be a peer it never signed as
+
+	n := NewCustom(nil, testSubscriptionChangeConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	sc := &subscriptionChange{peer: &spoofedPeer, topicDigest: digest, join: true, nonce: 1}
+	n.signSubscriptionChange(sc)
+
+	n.handleSubscriptionChange(sc)
+
+	if _, ok := n.peers[spoofedPeer]; ok {
+		t.Error("expected a subscriptionChange signed by a different key than it claims to be rejected")
+	}
+}
+
+func TestHandleSubscriptionChangeAcceptsValidSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var peer pubkey
+	copy(peer[:], pub)
+
+	n := NewCustom(nil, testSubscriptionChangeConfig())
+	n.SetSigner(priv)
+
+	var digest hash160digest
+	sc := &subscriptionChange{peer: &peer, topicDigest: digest, join: true, nonce: 1}
+	n.signSubscriptionChange(sc)
+
+	n.handleSubscriptionChange(sc)
+
+	if _, ok := n.peers[peer]; !ok {
+		t.Error("expected a correctly signed subscriptionChange to be applied")
+	}
+}
+
+func TestFloodSubscriptionChangeSignsItsNotification(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	var self, neighbour pubkey
+	copy(self[:], pub)
+	copy(neighbour[:], []byte("neighbour-peer-aaaaaaaaaaaaaaaaa"))
+
+	fo := newFakeOverlay(self)
+	neighbourOverlay := newFakeOverlay(neighbour)
+	fo.link(neighbourOverlay)
+
+	n := newNode(fo, nil, testSubscriptionChangeConfig())
+	n.SetSigner(priv)
+
+	digest := hash160([]byte("some-topic"))
+	n.floodSubscriptionChange(digest, true)
+
+	select {
+	case sc := <-neighbourOverlay.subscriptionChanges:
+		if len(sc.signature) == 0 {
+			t.Fatal("expected floodSubscriptionChange to sign its notification")
+		}
+		if !verifyFrame(self, subscriptionChangeKey(sc), sc.signature) {
+			t.Error("expected the signature to verify against the claimed peer")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a subscriptionChange to have been sent to the connected peer")
+	}
+}
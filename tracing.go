@@ -0,0 +1,200 @@
+package quasar
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Trace event kinds emitted by Node at its logging call sites.
+const (
+	TracePublish      = "PUBLISH"
+	TraceDeliver       = "DELIVER"
+	TraceDropTTL       = "DROP_TTL"
+	TraceDropDupe      = "DROP_DUPE"
+	TraceRouteDirect   = "ROUTE_DIRECT"
+	TraceRouteWell     = "ROUTE_WELL"
+	TraceRouteRandom   = "ROUTE_RANDOM"
+	TraceUpdateSent    = "UPDATE_SENT"
+	TraceUpdateRcv     = "UPDATE_RCV"
+	TraceUpdateSuccess = "UPDATE_SUCCESS"
+	TraceUpdateFail    = "UPDATE_FAIL"
+	TraceDropRateLimit = "DROP_RATE_LIMIT"
+	TraceSubJoin       = "SUB_JOIN"
+	TraceSubLeave      = "SUB_LEAVE"
+	TraceDropAuth      = "DROP_AUTH"
+)
+
+// TraceEvent is a single post-hoc-analyzable record of something that
+// happened inside a Node. Fields are left at their zero value when not
+// relevant to the Kind (e.g. FilterIndex is only set for UPDATE_* kinds
+// and for DROP_AUTH of an update).
+type TraceEvent struct {
+	Timestamp   int64  `json:"timestamp"`
+	NodeID      string `json:"node_id"`
+	Kind        string `json:"kind"`
+	TopicDigest string `json:"topic_digest,omitempty"`
+	PeerID      string `json:"peer_id,omitempty"`
+	TTL         uint32 `json:"ttl,omitempty"`
+	FilterIndex uint32 `json:"filter_index,omitempty"`
+}
+
+// Tracer receives TraceEvents from a Node. Implementations must not
+// block, since Trace is called from Node's hot path.
+type Tracer interface {
+	Trace(evt *TraceEvent)
+}
+
+// JSONTracer is a Tracer that writes newline-delimited JSON records to
+// an io.Writer from a background goroutine, so operators can post-process
+// large runs offline without slowing the node down. It is backed by a
+// bounded ring buffer: once full, the oldest pending event is dropped to
+// make room rather than blocking the caller.
+type JSONTracer struct {
+	buf  chan *TraceEvent
+	stop chan struct{}
+	done chan struct{}
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewJSONTracer starts a JSONTracer that writes to w, buffering up to
+// size pending events before it starts dropping the oldest ones.
+func NewJSONTracer(w io.Writer, size int) *JSONTracer {
+	t := &JSONTracer{
+		buf:  make(chan *TraceEvent, size),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		enc:  json.NewEncoder(w),
+	}
+	go t.flushLoop()
+	return t
+}
+
+// Trace enqueues evt for the background flusher. It never blocks: when
+// the ring buffer is full the oldest queued event is dropped.
+func (t *JSONTracer) Trace(evt *TraceEvent) {
+	select {
+	case t.buf <- evt:
+		return
+	default:
+	}
+	select {
+	case <-t.buf:
+	default:
+	}
+	select {
+	case t.buf <- evt:
+	default:
+	}
+}
+
+func (t *JSONTracer) flushLoop() {
+	defer close(t.done)
+	for {
+		select {
+		case evt := <-t.buf:
+			t.write(evt)
+		case <-t.stop:
+			for {
+				select {
+				case evt := <-t.buf:
+					t.write(evt)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *JSONTracer) write(evt *TraceEvent) {
+	t.mu.Lock()
+	t.enc.Encode(evt) // best effort; a write failure just drops this record
+	t.mu.Unlock()
+}
+
+// Close stops the background flusher once the ring buffer has been
+// drained, flushing any events queued before the call.
+func (t *JSONTracer) Close() error {
+	close(t.stop)
+	<-t.done
+	return nil
+}
+
+func pubkeyHex(p pubkey) string {
+	return hex.EncodeToString(p[:])
+}
+
+// traceEvent builds and emits a TraceEvent for an event-related call
+// site (publish/deliver/drop/route). It is a no-op if n has no tracer.
+func (n *Node) traceEvent(kind string, e *event, peer *pubkey) {
+	n.mutex.RLock()
+	tracer := n.tracer
+	n.mutex.RUnlock()
+	if tracer == nil {
+		return
+	}
+	te := &TraceEvent{
+		Timestamp: time.Now().UnixNano(),
+		NodeID:    pubkeyHex(n.net.id()),
+		Kind:      kind,
+	}
+	if e != nil && e.topicDigest != nil {
+		te.TopicDigest = hex.EncodeToString(e.topicDigest[:])
+		te.TTL = e.ttl
+	}
+	if peer != nil {
+		te.PeerID = pubkeyHex(*peer)
+	}
+	tracer.Trace(te)
+}
+
+// traceSubscriptionChange emits a TraceEvent for a received
+// subscriptionChange notification. It is a no-op if n has no tracer.
+func (n *Node) traceSubscriptionChange(sc *subscriptionChange) {
+	n.mutex.RLock()
+	tracer := n.tracer
+	n.mutex.RUnlock()
+	if tracer == nil || sc == nil {
+		return
+	}
+	kind := TraceSubLeave
+	if sc.join {
+		kind = TraceSubJoin
+	}
+	te := &TraceEvent{
+		Timestamp:   time.Now().UnixNano(),
+		NodeID:      pubkeyHex(n.net.id()),
+		Kind:        kind,
+		TopicDigest: hex.EncodeToString(sc.topicDigest[:]),
+	}
+	if sc.peer != nil {
+		te.PeerID = pubkeyHex(*sc.peer)
+	}
+	tracer.Trace(te)
+}
+
+// traceUpdate builds and emits a TraceEvent for an update-related call
+// site (sent/received/success/fail, or an update dropped by
+// authentication). It is a no-op if n has no tracer.
+func (n *Node) traceUpdate(kind string, index uint32, peer *pubkey) {
+	n.mutex.RLock()
+	tracer := n.tracer
+	n.mutex.RUnlock()
+	if tracer == nil {
+		return
+	}
+	te := &TraceEvent{
+		Timestamp:   time.Now().UnixNano(),
+		NodeID:      pubkeyHex(n.net.id()),
+		Kind:        kind,
+		FilterIndex: index,
+	}
+	if peer != nil {
+		te.PeerID = pubkeyHex(*peer)
+	}
+	tracer.Trace(te)
+}
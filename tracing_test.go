@@ -0,0 +1,64 @@
+package quasar
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordingTracer is a minimal Tracer that counts Trace calls under its
+// own mutex, so the only unsynchronized access -race can flag is the
+// Node's own.
+type recordingTracer struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (r *recordingTracer) Trace(evt *TraceEvent) {
+	r.mu.Lock()
+	r.count++
+	r.mu.Unlock()
+}
+
+// TestTraceCallsAreRaceFreeWithSetTracer drives traceEvent/traceUpdate/
+// traceSubscriptionChange concurrently with SetTracer, the way node.go's
+// goroutine-heavy call sites (go n.route(...), go n.processUpdate(...))
+// actually do. Run with -race to catch an unsynchronized read of
+// n.tracer.
+func TestTraceCallsAreRaceFreeWithSetTracer(t *testing.T) {
+	var self pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	n := newNode(newFakeOverlay(self), nil, &StandardConfig)
+
+	var digest hash160digest
+	e := &event{topicDigest: &digest, ttl: 5}
+	sc := &subscriptionChange{peer: &self, topicDigest: digest, join: true}
+	tr := &recordingTracer{}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for _, fn := range []func(){
+		func() { n.traceEvent(TracePublish, e, nil) },
+		func() { n.traceUpdate(TraceUpdateSent, 0, &self) },
+		func() { n.traceSubscriptionChange(sc) },
+	} {
+		fn := fn
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					fn()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		n.SetTracer(tr)
+	}
+	close(done)
+	readers.Wait()
+}
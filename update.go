@@ -1,21 +1,47 @@
 package quasar
 
 type update struct {
-	peer   *pubkey
-	index  uint32
-	filter []byte
+	peer      *pubkey
+	index     uint32
+	filter    []byte
+	counter   uint64 // monotonic per-sender counter, replay protection
+	signature []byte // Ed25519 signature over the rest of the encoded frame
 }
 
-func validUpdate(u *update, c *config) bool {
+func validUpdate(u *update, c *Config) bool {
 	return u != nil && u.peer != nil &&
-		u.index < (c.filtersDepth-1) && // top filter never propagated
-		uint64(len(u.filter)) == (c.filtersM/8)
+		u.index < (c.FiltersDepth-1) && // top filter never propagated
+		uint64(len(u.filter)) == (c.FiltersM/8)
 }
 
-func serializeUpdate(u *update) []byte {
-	return nil // TODO implement
+func serializeUpdate(u *update, codec Codec) []byte {
+	data, err := codec.EncodeUpdate(u)
+	if err != nil {
+		return nil
+	}
+	return data
 }
 
-func deserializeUpdate(data []byte) *update {
-	return nil // TODO implement
+func deserializeUpdate(data []byte, codec Codec, c *Config) *update {
+	u, err := codec.DecodeUpdate(data, c)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+func serializeEvent(e *event, codec Codec) []byte {
+	data, err := codec.EncodeEvent(e)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func deserializeEvent(data []byte, codec Codec, c *Config) *event {
+	e, err := codec.DecodeEvent(data, c)
+	if err != nil {
+		return nil
+	}
+	return e
 }
\ No newline at end of file
@@ -0,0 +1,130 @@
+package quasar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testWireConfig() *Config {
+	c := StandardConfig
+	c.MaxPublishers = 8
+	c.MaxMessageSize = 1024
+	return &c
+}
+
+func TestUpdateRoundTrip(t *testing.T) {
+	c := testWireConfig()
+	var p pubkey
+	copy(p[:], []byte("01234567890123456789012345678901"))
+	filter := make([]byte, c.FiltersM/8)
+	filter[3] = 0xAB
+
+	in := &update{peer: &p, index: 2, filter: filter}
+	data := serializeUpdate(in, defaultCodec)
+	if data == nil {
+		t.Fatal("serializeUpdate returned nil")
+	}
+
+	out := deserializeUpdate(data, defaultCodec, c)
+	if out == nil {
+		t.Fatal("deserializeUpdate returned nil")
+	}
+	if *out.peer != *in.peer {
+		t.Errorf("peer mismatch: got %v want %v", *out.peer, *in.peer)
+	}
+	if out.index != in.index {
+		t.Errorf("index mismatch: got %d want %d", out.index, in.index)
+	}
+	if !bytes.Equal(out.filter, in.filter) {
+		t.Errorf("filter mismatch: got %v want %v", out.filter, in.filter)
+	}
+}
+
+func TestUpdateRoundTripRejectsOversizedFilter(t *testing.T) {
+	c := testWireConfig()
+	var p pubkey
+	in := &update{peer: &p, index: 0, filter: make([]byte, c.FiltersM/8)}
+	data := serializeUpdate(in, defaultCodec)
+
+	small := testWireConfig()
+	small.FiltersM = 8 // 1 byte filters, smaller than the encoded frame
+	if out := deserializeUpdate(data, defaultCodec, small); out != nil {
+		t.Error("expected deserializeUpdate to reject an oversized filter")
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	c := testWireConfig()
+	var digest hash160digest
+	copy(digest[:], []byte("0123456789012345"))
+	var p1, p2 pubkey
+	copy(p1[:], []byte("publisher-one-aaaaaaaaaaaaaaaaaa"))
+	copy(p2[:], []byte("publisher-two-bbbbbbbbbbbbbbbbbb"))
+
+	in := &event{
+		topicDigest: &digest,
+		ttl:         5,
+		publishers:  []pubkey{p1, p2},
+		message:     []byte("hello quasar"),
+	}
+	data := serializeEvent(in, defaultCodec)
+	if data == nil {
+		t.Fatal("serializeEvent returned nil")
+	}
+
+	out := deserializeEvent(data, defaultCodec, c)
+	if out == nil {
+		t.Fatal("deserializeEvent returned nil")
+	}
+	if *out.topicDigest != *in.topicDigest {
+		t.Errorf("topic digest mismatch: got %v want %v", *out.topicDigest, *in.topicDigest)
+	}
+	if out.ttl != in.ttl {
+		t.Errorf("ttl mismatch: got %d want %d", out.ttl, in.ttl)
+	}
+	if !bytes.Equal(out.message, in.message) {
+		t.Errorf("message mismatch: got %v want %v", out.message, in.message)
+	}
+	if len(out.publishers) != len(in.publishers) {
+		t.Fatalf("publisher count mismatch: got %d want %d", len(out.publishers), len(in.publishers))
+	}
+	for i := range in.publishers {
+		if out.publishers[i] != in.publishers[i] {
+			t.Errorf("publisher %d mismatch: got %v want %v", i, out.publishers[i], in.publishers[i])
+		}
+	}
+}
+
+// customCodec wraps another Codec so tests can prove Start propagated a
+// specific, distinguishable Codec value rather than happening to pass
+// through the pre-existing defaultCodec.
+type customCodec struct{ Codec }
+
+func TestStartGivesOverlayTheConfiguredCodec(t *testing.T) {
+	var self pubkey
+	copy(self[:], []byte("self-node-aaaaaaaaaaaaaaaaaaaaaaa"))
+	fo := newFakeOverlay(self)
+
+	n := newNode(fo, nil, &StandardConfig)
+	cc := customCodec{defaultCodec}
+	n.SetCodec(cc)
+
+	n.Start()
+	defer n.Stop()
+
+	if fo.codec != Codec(cc) {
+		t.Error("expected Start to push the Node's configured codec to an overlay that accepts one via SetCodec")
+	}
+}
+
+func TestEventRoundTripRejectsOversizedMessage(t *testing.T) {
+	var digest hash160digest
+	in := &event{topicDigest: &digest, ttl: 1, message: make([]byte, 64)}
+	data := serializeEvent(in, defaultCodec)
+
+	small := testWireConfig()
+	small.MaxMessageSize = 8
+	if out := deserializeEvent(data, defaultCodec, small); out != nil {
+		t.Error("expected deserializeEvent to reject an oversized message")
+	}
+}